@@ -0,0 +1,147 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// NewController creates a Controller that reconciles probeDependantsList.
+// metrics holds the collectors the Controller records into; build it with
+// NewMetrics against a registry of the caller's choosing instead of
+// prometheus.DefaultRegisterer, or pass nil to fall back to DefaultMetrics(),
+// preserving the dependency-watchdog binary's historical behavior.
+func NewController(
+	client kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	mapper apimeta.RESTMapper,
+	scalesGetter scale.ScalesGetter,
+	informerFactory informers.SharedInformerFactory,
+	probeDependantsList *ProbeDependantsList,
+	metrics *Metrics,
+	stopCh <-chan struct{},
+) (*Controller, error) {
+	if err := probeDependantsList.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid probeDependantsList: %w", err)
+	}
+	if metrics == nil {
+		metrics = DefaultMetrics()
+	}
+
+	secretsInformer := informerFactory.Core().V1().Secrets()
+	deploymentsInformer := informerFactory.Apps().V1().Deployments()
+
+	return &Controller{
+		client:              client,
+		mapper:              mapper,
+		dynamicClient:       dynamicClient,
+		scalesGetter:        scalesGetter,
+		informerFactory:     informerFactory,
+		secretsInformer:     secretsInformer.Informer(),
+		secretsLister:       secretsInformer.Lister(),
+		deploymentsInformer: deploymentsInformer.Informer(),
+		deploymentsLister:   deploymentsInformer.Lister(),
+		workqueue: workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{
+			Name: "scaler",
+		}),
+		hasSecretsSynced:     secretsInformer.Informer().HasSynced,
+		hasDeploymentsSynced: deploymentsInformer.Informer().HasSynced,
+		stopCh:               stopCh,
+		probeDependantsList:  probeDependantsList,
+		probers:              make(map[string]*prober),
+		proberStopChs:        make(map[string]chan struct{}),
+		metrics:              metrics,
+	}, nil
+}
+
+// Run starts c's informers and blocks until their caches have synced, then
+// starts a prober - and reconciles its ServiceMonitor - for every entry in
+// c.probeDependantsList.
+func (c *Controller) Run(ctx context.Context) error {
+	c.informerFactory.Start(c.stopCh)
+	if !cache.WaitForCacheSync(c.stopCh, c.hasSecretsSynced, c.hasDeploymentsSynced) {
+		return fmt.Errorf("failed waiting for scaler informer caches to sync")
+	}
+	return c.reconcileProbeDependantsList(ctx)
+}
+
+// reconcileProbeDependantsList starts a prober goroutine for every
+// probeDependants entry that isn't already running one, and stops any prober
+// whose entry was removed from c.probeDependantsList.
+func (c *Controller) reconcileProbeDependantsList(ctx context.Context) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	namespace := c.probeDependantsList.Namespace
+	seen := make(map[string]bool, len(c.probeDependantsList.Probes))
+	for _, dependants := range c.probeDependantsList.Probes {
+		key := proberKey(namespace, dependants.Name)
+		seen[key] = true
+
+		if err := c.reconcileServiceMonitor(ctx, namespace, dependants, c.probeDependantsList.Monitoring); err != nil {
+			klog.Errorf("could not reconcile ServiceMonitor for probe %s/%s: %v", namespace, dependants.Name, err)
+		}
+
+		if _, running := c.probers[key]; !running {
+			proberStopCh := make(chan struct{})
+			p := newProber(namespace, dependants, c.mapper, c.secretsLister, c.scalesGetter, c.metrics, proberStopCh)
+			c.probers[key] = p
+			c.proberStopChs[key] = proberStopCh
+			c.metrics.Probers.With(prometheus.Labels{labelNamespace: namespace, labelProbe: dependants.Name}).Inc()
+			c.metrics.ProberRunning.With(prometheus.Labels{labelNamespace: namespace, labelProbe: dependants.Name}).Set(1)
+			go p.run(ctx)
+		}
+	}
+
+	for key := range c.probers {
+		if seen[key] {
+			continue
+		}
+		c.stopProber(ctx, namespace, key)
+	}
+	return nil
+}
+
+// stopProber stops the prober running for key, removes it from c.probers, and
+// deletes the ServiceMonitor reconciled for its probeDependants entry.
+func (c *Controller) stopProber(ctx context.Context, namespace, key string) {
+	name := proberName(namespace, key)
+	close(c.proberStopChs[key])
+	delete(c.proberStopChs, key)
+	delete(c.probers, key)
+	c.metrics.ProberRunning.With(prometheus.Labels{labelNamespace: namespace, labelProbe: name}).Set(0)
+	if err := c.deleteServiceMonitor(ctx, namespace, name); err != nil {
+		klog.Errorf("could not delete ServiceMonitor for probe %s/%s: %v", namespace, name, err)
+	}
+}
+
+func proberKey(namespace, probeName string) string {
+	return namespace + "/" + probeName
+}
+
+func proberName(namespace, key string) string {
+	return key[len(namespace)+1:]
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Metrics holds all dwd_* Prometheus collectors used by a scaler.Controller.
+// It is constructed per Controller instead of being registered against
+// prometheus.DefaultRegisterer, so that multiple Controllers - e.g. one per
+// watched cluster, or one per parallel test - can run in the same process
+// without clashing on global metric registration.
+type Metrics struct {
+	Probers                     *prometheus.CounterVec
+	GetTargetFromCacheTotal     *prometheus.CounterVec
+	InternalProbes              *prometheus.CounterVec
+	ExternalProbes              *prometheus.CounterVec
+	ScaleRequestsTotal          *prometheus.CounterVec
+	ThrottledScaleRequestsTotal *prometheus.CounterVec
+	// ProberRunning is 1 for a <namespace, probe> while its prober goroutine is active, 0 otherwise.
+	ProberRunning *prometheus.GaugeVec
+	// DependantScaledDown is 1 for a <namespace, probe> while the watchdog has its
+	// dependantScaleDetails pinned to zero replicas, 0 once it is restored.
+	DependantScaledDown *prometheus.GaugeVec
+}
+
+// NewMetrics creates the dwd_* collectors and registers them against reg,
+// alongside the client-go workqueue and REST client collectors so that
+// Controller.workqueue depth/adds/retries and scale-client request latencies
+// are exported under the same dwd_ namespace. If a collector is already
+// registered against reg - e.g. because reg is prometheus.DefaultRegisterer
+// and another Controller already registered it - the already-registered
+// collector is reused instead of failing.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	probeLabels := []string{labelNamespace, labelProbe}
+	m := &Metrics{
+		Probers: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "probers_total",
+				Help:      "The accumulated total number of probers started by the dependency-watchdog.",
+			},
+			probeLabels,
+		),
+		GetTargetFromCacheTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "get_from_cache_total",
+				Help:      "The accumulated total number get calls done by the dependency-watchdog on the local cache.",
+			},
+			append(probeLabels, labelResource),
+		),
+		InternalProbes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "internal_probes_total",
+				Help:      "The accumulated total number of internal probes done by the dependency-watchdog.",
+			},
+			append(probeLabels, labelResult),
+		),
+		ExternalProbes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "external_probes_total",
+				Help:      "The accumulated total number of external probes done by the dependency-watchdog.",
+			},
+			append(probeLabels, labelResult, labelSource),
+		),
+		ScaleRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "scale_requests_total",
+				Help:      "The accumulated total number of scale client requests made by the dependency-watchdog.",
+			},
+			append(probeLabels, labelVerb),
+		),
+		ThrottledScaleRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "throttled_scale_requests_total",
+				Help:      "The accumulated total number of throttled scale client requests made by the dependency-watchdog.",
+			},
+			append(probeLabels, labelVerb),
+		),
+		ProberRunning: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "prober_running",
+				Help:      "1 while a prober goroutine for the <namespace, probe> is active, 0 otherwise.",
+			},
+			probeLabels,
+		),
+		DependantScaledDown: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: dwdNamespace,
+				Subsystem: subsystemAggregate,
+				Name:      "dependant_scaled_down",
+				Help:      "1 while the <namespace, probe> dependant scales are pinned to zero by the watchdog, 0 once restored.",
+			},
+			probeLabels,
+		),
+	}
+
+	m.mustRegister(reg)
+	registerClientGoMetrics(reg)
+	return m
+}
+
+// DefaultMetrics returns a Metrics registered against prometheus.DefaultRegisterer,
+// preserving the behaviour the dependency-watchdog binary relied on before
+// per-Controller registries were introduced.
+func DefaultMetrics() *Metrics {
+	return NewMetrics(prometheus.DefaultRegisterer)
+}
+
+// mustRegister registers every collector in m against reg, falling back to the
+// already-registered collector - and reusing it - whenever reg reports an
+// AlreadyRegisteredError for one of them.
+func (m *Metrics) mustRegister(reg prometheus.Registerer) {
+	counters := []**prometheus.CounterVec{
+		&m.Probers,
+		&m.GetTargetFromCacheTotal,
+		&m.InternalProbes,
+		&m.ExternalProbes,
+		&m.ScaleRequestsTotal,
+		&m.ThrottledScaleRequestsTotal,
+	}
+	for _, c := range counters {
+		if err := reg.Register(*c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				*c = are.ExistingCollector.(*prometheus.CounterVec)
+				continue
+			}
+			panic(err)
+		}
+	}
+
+	gauges := []**prometheus.GaugeVec{
+		&m.ProberRunning,
+		&m.DependantScaledDown,
+	}
+	for _, g := range gauges {
+		if err := reg.Register(*g); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				*g = are.ExistingCollector.(*prometheus.GaugeVec)
+				continue
+			}
+			panic(err)
+		}
+	}
+}
+
+// registerClientGoMetrics wires client-go's workqueue depth/adds/latency/retries
+// metrics and its REST client request latency/result metrics into reg under the
+// dwd_ namespace, so Controller.workqueue and the scale client show up next to
+// the rest of the scaler's metrics. Both client-go metrics hooks are process-wide
+// globals, so registration against the first reg passed in wins; subsequent
+// Controllers sharing that reg are no-ops via the AlreadyRegisteredError fallback.
+func registerClientGoMetrics(reg prometheus.Registerer) {
+	workqueue.SetProvider(newWorkqueueMetricsProvider(reg))
+	metrics.Register(metrics.RegisterOpts{
+		RequestLatency: newRequestLatencyMetric(reg),
+		RequestResult:  newRequestResultMetric(reg),
+	})
+}
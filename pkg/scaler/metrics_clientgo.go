@@ -0,0 +1,187 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	restmetrics "k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// subsystemWorkqueue and subsystemRestClient group the client-go-sourced
+// collectors alongside the hand-written dwd_aggr_* ones.
+const (
+	subsystemWorkqueue  = "workqueue"
+	subsystemRestClient = "rest_client"
+	labelVerbLatency    = "verb"
+	labelCode           = "code"
+	labelMethod         = "method"
+	labelHost           = "host"
+)
+
+// workqueueMetricsProvider feeds Controller.workqueue's depth/adds/latency/retries
+// into reg under the dwd_workqueue_ subsystem, implementing workqueue.MetricsProvider.
+type workqueueMetricsProvider struct {
+	reg prometheus.Registerer
+}
+
+func newWorkqueueMetricsProvider(reg prometheus.Registerer) *workqueueMetricsProvider {
+	return &workqueueMetricsProvider{reg: reg}
+}
+
+func (p *workqueueMetricsProvider) registerGauge(name, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: dwdNamespace, Subsystem: subsystemWorkqueue, Name: name, Help: help})
+	return registerOrReuse(p.reg, g).(prometheus.Gauge)
+}
+
+func (p *workqueueMetricsProvider) registerCounter(name, help string) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Namespace: dwdNamespace, Subsystem: subsystemWorkqueue, Name: name, Help: help})
+	return registerOrReuse(p.reg, c).(prometheus.Counter)
+}
+
+func (p *workqueueMetricsProvider) registerHistogram(name, help string) prometheus.Histogram {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: dwdNamespace, Subsystem: subsystemWorkqueue, Name: name, Help: help})
+	return registerOrReuse(p.reg, h).(prometheus.Histogram)
+}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.registerGauge(name+"_depth", "Current depth of the "+name+" workqueue.")
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.registerCounter(name+"_adds_total", "Total number of adds handled by the "+name+" workqueue.")
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return p.registerHistogram(name+"_queue_duration_seconds", "Time items spent waiting in the "+name+" workqueue before being processed.")
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return p.registerHistogram(name+"_work_duration_seconds", "Time spent processing items taken off the "+name+" workqueue.")
+}
+
+func (p *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.registerGauge(name+"_unfinished_work_seconds", "Seconds of in-flight work on the "+name+" workqueue that has not yet been observed.")
+}
+
+func (p *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.registerGauge(name+"_longest_running_processor_seconds", "Age of the oldest in-flight item on the "+name+" workqueue.")
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.registerCounter(name+"_retries_total", "Total number of retries handled by the "+name+" workqueue.")
+}
+
+// The Deprecated* variants exist only to satisfy workqueue.MetricsProvider; the
+// dependency-watchdog does not export the metrics client-go itself marks deprecated.
+func (p *workqueueMetricsProvider) NewDeprecatedDepthMetric(string) workqueue.GaugeMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedAddsMetric(string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedLatencyMetric(string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedWorkDurationMetric(string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedUnfinishedWorkSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedLongestRunningProcessorMicrosecondsMetric(string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+func (p *workqueueMetricsProvider) NewDeprecatedRetriesMetric(string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+// noopMetric discards every sample. It is returned for the workqueue metrics
+// client-go itself marks deprecated, which this package intentionally does not export.
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Set(float64)     {}
+func (noopMetric) Observe(float64) {}
+
+// registerOrReuse registers c against reg, returning the already-registered
+// collector instead of panicking if an equivalent one was registered before -
+// e.g. by another Controller sharing a prometheus.DefaultRegisterer.
+func registerOrReuse(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+// requestLatencyMetric reports per-verb REST client request latencies for the
+// scale client (and any other client-go REST client sharing this process) under
+// dwd_rest_client_request_latency_seconds.
+type requestLatencyMetric struct {
+	histogram *prometheus.HistogramVec
+}
+
+func newRequestLatencyMetric(reg prometheus.Registerer) *requestLatencyMetric {
+	hv := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemRestClient,
+			Name:      "request_latency_seconds",
+			Help:      "Latency, in seconds, of REST client requests made by the dependency-watchdog.",
+		},
+		[]string{labelVerbLatency},
+	)
+	return &requestLatencyMetric{histogram: registerOrReuse(reg, hv).(*prometheus.HistogramVec)}
+}
+
+func (r *requestLatencyMetric) Observe(_ context.Context, verb string, _ url.URL, latency time.Duration) {
+	r.histogram.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// requestResultMetric reports per-code REST client request results under
+// dwd_rest_client_requests_total.
+type requestResultMetric struct {
+	counter *prometheus.CounterVec
+}
+
+func newRequestResultMetric(reg prometheus.Registerer) *requestResultMetric {
+	cv := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemRestClient,
+			Name:      "requests_total",
+			Help:      "Total number of REST client requests made by the dependency-watchdog, by result code.",
+		},
+		[]string{labelCode, labelMethod, labelHost},
+	)
+	return &requestResultMetric{counter: registerOrReuse(reg, cv).(*prometheus.CounterVec)}
+}
+
+func (r *requestResultMetric) Increment(_ context.Context, code, method, host string) {
+	r.counter.WithLabelValues(code, method, host).Inc()
+}
+
+var (
+	_ restmetrics.LatencyMetric = (*requestLatencyMetric)(nil)
+	_ restmetrics.ResultMetric  = (*requestResultMetric)(nil)
+)
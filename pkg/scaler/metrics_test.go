@@ -0,0 +1,58 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewMetricsOnFreshRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+
+	m.ExternalProbes.With(prometheus.Labels{
+		labelNamespace: "shoot--foo--bar", labelProbe: "kube-apiserver", labelResult: resultSuccess, labelSource: sourceKubeconfig,
+	}).Inc()
+
+	if got := testutil.ToFloat64(m.ExternalProbes.With(prometheus.Labels{
+		labelNamespace: "shoot--foo--bar", labelProbe: "kube-apiserver", labelResult: resultSuccess, labelSource: sourceKubeconfig,
+	})); got != 1 {
+		t.Fatalf("expected ExternalProbes to read 1, got %v", got)
+	}
+}
+
+func TestNewMetricsSharedRegistryReusesCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	first := NewMetrics(reg)
+	second := NewMetrics(reg)
+
+	if first.ExternalProbes != second.ExternalProbes {
+		t.Fatal("expected the second Metrics sharing reg to reuse the first's already-registered ExternalProbes collector")
+	}
+
+	first.ExternalProbes.With(prometheus.Labels{
+		labelNamespace: "shoot--foo--bar", labelProbe: "kube-apiserver", labelResult: resultFailure, labelSource: sourcePromQL,
+	}).Inc()
+
+	if got := testutil.ToFloat64(second.ExternalProbes.With(prometheus.Labels{
+		labelNamespace: "shoot--foo--bar", labelProbe: "kube-apiserver", labelResult: resultFailure, labelSource: sourcePromQL,
+	})); got != 1 {
+		t.Fatalf("expected second Metrics to observe the increment made through first, got %v", got)
+	}
+}
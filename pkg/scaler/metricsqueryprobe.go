@@ -0,0 +1,160 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// prometheusQueryResult is the subset of the Prometheus HTTP API's instant-query
+// response needed to evaluate a MetricsQuery probe.
+// See https://prometheus.io/docs/prometheus/latest/querying/api/#instant-queries.
+type prometheusQueryResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// evaluateMetricsQuery issues a `/api/v1/query` GET against cfg.Endpoint for
+// cfg.Query and reports whether the returned instant-vector/scalar result
+// satisfies cfg.Threshold. authToken, resolved by the caller from
+// cfg.AuthSecretRef, is sent as a bearer token when non-empty.
+func evaluateMetricsQuery(ctx context.Context, httpClient *http.Client, authToken string, cfg *metricsQueryProbeDetails) (bool, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return false, fmt.Errorf("invalid metricsQuery endpoint %q: %w", cfg.Endpoint, err)
+	}
+	endpoint.Path = path.Join(endpoint.Path, "api/v1/query")
+	q := endpoint.Query()
+	q.Set("query", cfg.Query)
+	endpoint.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("metricsQuery request to %q failed: %w", cfg.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("metricsQuery request to %q returned status %d: %s", cfg.Endpoint, resp.StatusCode, body)
+	}
+
+	var result prometheusQueryResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, fmt.Errorf("could not decode metricsQuery response from %q: %w", cfg.Endpoint, err)
+	}
+	if result.Status != "success" {
+		return false, fmt.Errorf("metricsQuery %q against %q did not succeed, got status %q", cfg.Query, cfg.Endpoint, result.Status)
+	}
+
+	if cfg.Threshold == nil {
+		return false, fmt.Errorf("metricsQuery %q against %q has no threshold configured", cfg.Query, cfg.Endpoint)
+	}
+	value, empty, err := extractInstantValue(result.Data.ResultType, result.Data.Result)
+	if err != nil {
+		return false, err
+	}
+	if empty {
+		// An empty vector means the query's filter matched nothing, e.g.
+		// `up{job="kube-apiserver"} == 0` returns no series while the target is
+		// healthy. That is reported as "condition not satisfied", not as an error;
+		// the prober counts it as a failed probe like any other unsatisfied threshold.
+		return false, nil
+	}
+	return cfg.Threshold.evaluate(value)
+}
+
+// extractInstantValue pulls the single scalar/vector sample value out of a
+// decoded instant-query result. It reports empty=true, rather than an error,
+// when a vector result has no series - see the call site for why that case
+// is not treated as an error.
+func extractInstantValue(resultType string, raw json.RawMessage) (value float64, empty bool, err error) {
+	switch resultType {
+	case "scalar":
+		var sample [2]interface{}
+		if err := json.Unmarshal(raw, &sample); err != nil {
+			return 0, false, fmt.Errorf("could not decode scalar result: %w", err)
+		}
+		value, err = parseSampleValue(sample[1])
+		return value, false, err
+	case "vector":
+		var vector []struct {
+			Value [2]interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &vector); err != nil {
+			return 0, false, fmt.Errorf("could not decode vector result: %w", err)
+		}
+		if len(vector) == 0 {
+			return 0, true, nil
+		}
+		value, err = parseSampleValue(vector[0].Value[1])
+		return value, false, err
+	default:
+		return 0, false, fmt.Errorf("unsupported metricsQuery resultType %q, expected scalar or vector", resultType)
+	}
+}
+
+func parseSampleValue(v interface{}) (float64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected sample value type %T", v)
+	}
+	var f float64
+	if _, err := fmt.Sscanf(s, "%g", &f); err != nil {
+		return 0, fmt.Errorf("could not parse sample value %q: %w", s, err)
+	}
+	return f, nil
+}
+
+// evaluate reports whether value satisfies t. It returns an error, rather than
+// silently returning false, when t.Operator is not one of the recognized
+// comparison operators - e.g. a typo in the probeConfig - so a misconfigured
+// threshold surfaces as a probe error instead of a permanent, unexplained failure.
+func (t *thresholdPredicate) evaluate(value float64) (bool, error) {
+	switch t.Operator {
+	case "==":
+		return value == t.Value, nil
+	case "!=":
+		return value != t.Value, nil
+	case "<":
+		return value < t.Value, nil
+	case "<=":
+		return value <= t.Value, nil
+	case ">":
+		return value > t.Value, nil
+	case ">=":
+		return value >= t.Value, nil
+	default:
+		return false, fmt.Errorf("unrecognized threshold operator %q, expected one of ==, !=, <, <=, >, >=", t.Operator)
+	}
+}
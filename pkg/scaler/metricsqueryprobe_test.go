@@ -0,0 +1,131 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluateMetricsQueryVector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1234,"1"]}]}}`))
+	}))
+	defer srv.Close()
+
+	cfg := &metricsQueryProbeDetails{
+		Endpoint:  srv.URL,
+		Query:     `up{job="kube-apiserver"} == 0`,
+		Threshold: &thresholdPredicate{Operator: "==", Value: 1},
+	}
+	ok, err := evaluateMetricsQuery(context.Background(), srv.Client(), "", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected threshold to be satisfied")
+	}
+}
+
+func TestEvaluateMetricsQueryScalar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1234,"4"]}}`))
+	}))
+	defer srv.Close()
+
+	cfg := &metricsQueryProbeDetails{
+		Endpoint:  srv.URL,
+		Query:     `rate(etcd_server_leader_changes_seen_total[5m])`,
+		Threshold: &thresholdPredicate{Operator: ">", Value: 3},
+	}
+	ok, err := evaluateMetricsQuery(context.Background(), srv.Client(), "", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected threshold to be satisfied")
+	}
+}
+
+func TestEvaluateMetricsQueryEmptyVectorIsNotAnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer srv.Close()
+
+	// up{job="kube-apiserver"} == 0 returns a non-empty vector only for instances
+	// that are down; a healthy apiserver makes the filter match nothing.
+	cfg := &metricsQueryProbeDetails{
+		Endpoint:  srv.URL,
+		Query:     `up{job="kube-apiserver"} == 0`,
+		Threshold: &thresholdPredicate{Operator: "==", Value: 0},
+	}
+	ok, err := evaluateMetricsQuery(context.Background(), srv.Client(), "", cfg)
+	if err != nil {
+		t.Fatalf("expected an empty vector to be reported as condition-not-satisfied, not an error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected an empty vector (nothing down) not to satisfy the probe")
+	}
+}
+
+func TestEvaluateMetricsQuerySendsBearerToken(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1234,"4"]}}`))
+	}))
+	defer srv.Close()
+
+	cfg := &metricsQueryProbeDetails{
+		Endpoint:  srv.URL,
+		Query:     "up",
+		Threshold: &thresholdPredicate{Operator: ">", Value: 0},
+	}
+	if _, err := evaluateMetricsQuery(context.Background(), srv.Client(), "s3cr3t", cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "Bearer s3cr3t"; gotAuth != want {
+		t.Fatalf("expected Authorization header %q, got %q", want, gotAuth)
+	}
+}
+
+func TestEvaluateMetricsQueryMissingThreshold(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1234,"4"]}}`))
+	}))
+	defer srv.Close()
+
+	cfg := &metricsQueryProbeDetails{Endpoint: srv.URL, Query: "up"}
+	if _, err := evaluateMetricsQuery(context.Background(), srv.Client(), "", cfg); err == nil {
+		t.Fatal("expected an error for a metricsQuery probe with no threshold")
+	}
+}
+
+func TestThresholdPredicateEvaluate(t *testing.T) {
+	if _, err := (&thresholdPredicate{Operator: "~="}).evaluate(1); err == nil {
+		t.Fatal("expected an error for an unrecognized operator")
+	}
+
+	ok, err := (&thresholdPredicate{Operator: ">=", Value: 3}).evaluate(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected 3 >= 3 to be satisfied")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// serviceMonitorGVR identifies the Prometheus Operator ServiceMonitor CRD that
+// monitoringReconciler manages via the dynamic client.
+var serviceMonitorGVR = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1",
+	Resource: "servicemonitors",
+}
+
+// serviceMonitorCRDPresent reports whether the ServiceMonitor CRD is registered
+// on the target cluster, so clusters without the Prometheus Operator installed
+// can be safely no-op'd instead of erroring out.
+func serviceMonitorCRDPresent(mapper apimeta.RESTMapper) bool {
+	_, err := mapper.RESTMapping(schema.GroupKind{Group: serviceMonitorGVR.Group, Kind: "ServiceMonitor"}, serviceMonitorGVR.Version)
+	return err == nil
+}
+
+// reconcileServiceMonitor creates or updates the ServiceMonitor for dependants'
+// dependant Deployments' Services according to cfg. It is a no-op, returning nil,
+// when the ServiceMonitor CRD is not present on the cluster. When cfg is nil -
+// monitoring was turned off for dependants while it stays in the
+// ProbeDependantsList - any ServiceMonitor previously reconciled for it is
+// deleted instead, so turning off monitoring doesn't leak a stale ServiceMonitor.
+func (c *Controller) reconcileServiceMonitor(ctx context.Context, namespace string, dependants probeDependants, cfg *monitoringConfig) error {
+	if cfg == nil {
+		return c.deleteServiceMonitor(ctx, namespace, dependants.Name)
+	}
+	if !serviceMonitorCRDPresent(c.mapper) {
+		return nil
+	}
+
+	endpoint := map[string]interface{}{
+		"port": cfg.Port,
+	}
+	if cfg.Path != "" {
+		endpoint["path"] = cfg.Path
+	}
+	if cfg.Interval != "" {
+		endpoint["interval"] = cfg.Interval
+	}
+	if cfg.ScrapeTimeout != "" {
+		endpoint["scrapeTimeout"] = cfg.ScrapeTimeout
+	}
+	if cfg.TLSConfig != nil {
+		endpoint["tlsConfig"] = cfg.TLSConfig
+	}
+	if len(cfg.Relabelings) > 0 {
+		relabelings := make([]interface{}, 0, len(cfg.Relabelings))
+		for _, r := range cfg.Relabelings {
+			relabelings = append(relabelings, r)
+		}
+		endpoint["relabelings"] = relabelings
+	}
+
+	// Select the Services labelled app=<name> of every dependant Deployment.
+	dependantNames := make([]interface{}, 0, len(dependants.DependantScales))
+	for _, ds := range dependants.DependantScales {
+		dependantNames = append(dependantNames, ds.ScaleRef.Name)
+	}
+
+	sm := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": serviceMonitorGVR.Group + "/" + serviceMonitorGVR.Version,
+			"kind":       "ServiceMonitor",
+			"metadata": map[string]interface{}{
+				"name":      serviceMonitorName(dependants.Name),
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchExpressions": []interface{}{
+						map[string]interface{}{
+							"key":      "app",
+							"operator": "In",
+							"values":   dependantNames,
+						},
+					},
+				},
+				"endpoints": []interface{}{endpoint},
+			},
+		},
+	}
+
+	client := c.dynamicClient.Resource(serviceMonitorGVR).Namespace(namespace)
+	existing, err := client.Get(ctx, sm.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.Create(ctx, sm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not get ServiceMonitor %s/%s: %w", namespace, sm.GetName(), err)
+	}
+
+	sm.SetResourceVersion(existing.GetResourceVersion())
+	_, err = client.Update(ctx, sm, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteServiceMonitor removes the ServiceMonitor reconciled for a probeDependants
+// entry that was removed from the ProbeDependantsList, no-op'ing when the CRD is
+// absent or the ServiceMonitor was never created.
+func (c *Controller) deleteServiceMonitor(ctx context.Context, namespace, name string) error {
+	if !serviceMonitorCRDPresent(c.mapper) {
+		return nil
+	}
+	err := c.dynamicClient.Resource(serviceMonitorGVR).Namespace(namespace).Delete(ctx, serviceMonitorName(name), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// serviceMonitorName derives the ServiceMonitor name reconciled for a probeDependants entry.
+func serviceMonitorName(probeName string) string {
+	return "dwd-" + probeName
+}
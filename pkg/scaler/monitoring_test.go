@@ -0,0 +1,125 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubernetesscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestReconcileServiceMonitorNoopWithoutCRD(t *testing.T) {
+	mapper := testrestmapper.TestOnlyStaticRESTMapper(kubernetesscheme.Scheme)
+	scheme := runtime.NewScheme()
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme)
+	c := &Controller{mapper: mapper, dynamicClient: dynamicClient}
+
+	cfg := &monitoringConfig{Port: "metrics"}
+	if err := c.reconcileServiceMonitor(context.Background(), "shoot--foo--bar", probeDependants{Name: "etcd"}, cfg); err != nil {
+		t.Fatalf("expected no-op when the ServiceMonitor CRD is absent, got error: %v", err)
+	}
+
+	if err := c.deleteServiceMonitor(context.Background(), "shoot--foo--bar", "etcd"); err != nil {
+		t.Fatalf("expected deleteServiceMonitor to also no-op when the CRD is absent, got error: %v", err)
+	}
+}
+
+func TestReconcileServiceMonitorCreatesAndDeletes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version, Kind: "ServiceMonitor"}, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version, Kind: "ServiceMonitorList"}, &unstructured.UnstructuredList{})
+	mapper := testrestmapper.TestOnlyStaticRESTMapper(scheme, schema.GroupVersion{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		serviceMonitorGVR: "ServiceMonitorList",
+	})
+	c := &Controller{mapper: mapper, dynamicClient: dynamicClient}
+
+	cfg := &monitoringConfig{Port: "metrics", Path: "/metrics"}
+	dependants := probeDependants{Name: "etcd"}
+	if err := c.reconcileServiceMonitor(context.Background(), "shoot--foo--bar", dependants, cfg); err != nil {
+		t.Fatalf("reconcileServiceMonitor create failed: %v", err)
+	}
+
+	sm, err := dynamicClient.Resource(serviceMonitorGVR).Namespace("shoot--foo--bar").Get(context.Background(), serviceMonitorName("etcd"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected ServiceMonitor %s to exist after reconcile: %v", serviceMonitorName("etcd"), err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	if len(endpoints) != 1 {
+		t.Fatalf("expected a single endpoint, got %d", len(endpoints))
+	}
+
+	cfg.Path = "/metrics2"
+	if err := c.reconcileServiceMonitor(context.Background(), "shoot--foo--bar", dependants, cfg); err != nil {
+		t.Fatalf("reconcileServiceMonitor update failed: %v", err)
+	}
+	sm, err = dynamicClient.Resource(serviceMonitorGVR).Namespace("shoot--foo--bar").Get(context.Background(), serviceMonitorName("etcd"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected ServiceMonitor to still exist after update: %v", err)
+	}
+	endpoints, _, _ = unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	if len(endpoints) != 1 || endpoints[0].(map[string]interface{})["path"] != "/metrics2" {
+		t.Fatalf("expected updated ServiceMonitor to carry the new path, got endpoints: %+v", endpoints)
+	}
+
+	if err := c.deleteServiceMonitor(context.Background(), "shoot--foo--bar", "etcd"); err != nil {
+		t.Fatalf("deleteServiceMonitor failed: %v", err)
+	}
+	_, err = dynamicClient.Resource(serviceMonitorGVR).Namespace("shoot--foo--bar").Get(context.Background(), serviceMonitorName("etcd"), metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected ServiceMonitor to be gone after delete, got err: %v", err)
+	}
+
+	// deleting an already-deleted ServiceMonitor again must stay a no-op.
+	if err := c.deleteServiceMonitor(context.Background(), "shoot--foo--bar", "etcd"); err != nil {
+		t.Fatalf("expected deleting an already-absent ServiceMonitor to be a no-op, got: %v", err)
+	}
+}
+
+func TestReconcileServiceMonitorDeletesWhenMonitoringTurnedOff(t *testing.T) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version, Kind: "ServiceMonitor"}, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version, Kind: "ServiceMonitorList"}, &unstructured.UnstructuredList{})
+	mapper := testrestmapper.TestOnlyStaticRESTMapper(scheme, schema.GroupVersion{Group: serviceMonitorGVR.Group, Version: serviceMonitorGVR.Version})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		serviceMonitorGVR: "ServiceMonitorList",
+	})
+	c := &Controller{mapper: mapper, dynamicClient: dynamicClient}
+	dependants := probeDependants{Name: "etcd"}
+
+	if err := c.reconcileServiceMonitor(context.Background(), "shoot--foo--bar", dependants, &monitoringConfig{Port: "metrics"}); err != nil {
+		t.Fatalf("reconcileServiceMonitor create failed: %v", err)
+	}
+	if _, err := dynamicClient.Resource(serviceMonitorGVR).Namespace("shoot--foo--bar").Get(context.Background(), serviceMonitorName("etcd"), metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected ServiceMonitor to exist before monitoring was turned off: %v", err)
+	}
+
+	// dependants stays in the ProbeDependantsList, but Monitoring is now nil.
+	if err := c.reconcileServiceMonitor(context.Background(), "shoot--foo--bar", dependants, nil); err != nil {
+		t.Fatalf("reconcileServiceMonitor with cfg=nil failed: %v", err)
+	}
+	_, err := dynamicClient.Resource(serviceMonitorGVR).Namespace("shoot--foo--bar").Get(context.Background(), serviceMonitorName("etcd"), metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the ServiceMonitor to be deleted once monitoring was turned off, got err: %v", err)
+	}
+}
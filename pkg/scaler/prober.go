@@ -0,0 +1,306 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+const defaultProbePeriod = 30 * time.Second
+
+// prober periodically evaluates the Internal/External/MetricsQuery probe(s)
+// configured for a single probeDependants entry and scales its DependantScales
+// to zero (or restores them) once SuccessThreshold/FailureThreshold consecutive
+// results have been seen for at least EvaluationDelay, feeding every outcome
+// into Metrics.
+type prober struct {
+	namespace    string
+	dependants   probeDependants
+	mapper       apimeta.RESTMapper
+	secretLister listerv1.SecretLister
+	scalesGetter scale.ScalesGetter
+	metrics      *Metrics
+	httpClient   *http.Client
+	period       time.Duration
+	successCount int32
+	failureCount int32
+	successSince time.Time
+	failureSince time.Time
+	scaledDown   bool
+	stopCh       <-chan struct{}
+}
+
+// newProber creates a prober for dependants, which lives in namespace.
+func newProber(namespace string, dependants probeDependants, mapper apimeta.RESTMapper, secretLister listerv1.SecretLister, scalesGetter scale.ScalesGetter, metrics *Metrics, stopCh <-chan struct{}) *prober {
+	period := defaultProbePeriod
+	if dependants.Probe != nil && dependants.Probe.PeriodSeconds != nil {
+		period = time.Duration(*dependants.Probe.PeriodSeconds) * time.Second
+	}
+	return &prober{
+		namespace:    namespace,
+		dependants:   dependants,
+		mapper:       mapper,
+		secretLister: secretLister,
+		scalesGetter: scalesGetter,
+		metrics:      metrics,
+		httpClient:   &http.Client{},
+		period:       period,
+		stopCh:       stopCh,
+	}
+}
+
+// run starts the probe loop on p.period; it blocks until p.stopCh is closed.
+func (p *prober) run(ctx context.Context) {
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.tick(ctx)
+		}
+	}
+}
+
+// tick runs one probe iteration, records the outcome against p.metrics, and
+// scales p.dependants.DependantScales up or down once the configured
+// SuccessThreshold/FailureThreshold consecutive results are seen.
+func (p *prober) tick(ctx context.Context) {
+	ok, source, err := p.probe(ctx)
+	if err != nil {
+		klog.Errorf("probe %s/%s failed: %v", p.namespace, p.dependants.Name, err)
+	}
+
+	result := resultFailure
+	if ok {
+		result = resultSuccess
+	}
+	if source != "" {
+		p.metrics.ExternalProbes.With(prometheus.Labels{
+			labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelResult: result, labelSource: source,
+		}).Inc()
+	} else {
+		p.metrics.InternalProbes.With(prometheus.Labels{
+			labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelResult: result,
+		}).Inc()
+	}
+
+	if !ok && source == "" {
+		// The Internal probe failed, so no External/MetricsQuery probe ran;
+		// DependantScales are left as they are.
+		return
+	}
+
+	successThreshold, failureThreshold := int32(1), int32(1)
+	if cfg := p.dependants.Probe; cfg != nil {
+		if cfg.SuccessThreshold != nil {
+			successThreshold = *cfg.SuccessThreshold
+		}
+		if cfg.FailureThreshold != nil {
+			failureThreshold = *cfg.FailureThreshold
+		}
+	}
+
+	if ok {
+		if p.successCount == 0 {
+			p.successSince = time.Now()
+		}
+		p.successCount++
+		p.failureCount = 0
+		if p.scaledDown && p.successCount >= successThreshold && p.evaluationDelayElapsed(p.successSince) {
+			p.restoreScale(ctx)
+		}
+		return
+	}
+	if p.failureCount == 0 {
+		p.failureSince = time.Now()
+	}
+	p.failureCount++
+	p.successCount = 0
+	if !p.scaledDown && p.failureCount >= failureThreshold && p.evaluationDelayElapsed(p.failureSince) {
+		p.scaleDown(ctx)
+	}
+}
+
+// evaluationDelayElapsed reports whether p.dependants.EvaluationDelay has
+// elapsed since since, the time the current run of consecutive
+// success/failure results started. A nil EvaluationDelay always reports true,
+// so a scale change still happens as soon as SuccessThreshold/FailureThreshold
+// consecutive results are seen.
+func (p *prober) evaluationDelayElapsed(since time.Time) bool {
+	if p.dependants.EvaluationDelay == nil {
+		return true
+	}
+	return time.Since(since) >= time.Duration(*p.dependants.EvaluationDelay)*time.Second
+}
+
+// probe runs the configured Internal probe, if any, followed by whichever of
+// MetricsQuery or External is configured. It returns the overall outcome and,
+// for the MetricsQuery/External leg, the source label ("promql"/"kubeconfig")
+// that produced it - empty when only the Internal probe ran.
+func (p *prober) probe(ctx context.Context) (ok bool, source string, err error) {
+	cfg := p.dependants.Probe
+	if cfg == nil {
+		return true, "", nil
+	}
+
+	if cfg.Internal != nil {
+		if ok, err = p.probeKubeconfig(ctx, cfg.Internal); err != nil || !ok {
+			return ok, "", err
+		}
+	}
+
+	switch {
+	case cfg.MetricsQuery != nil:
+		authToken, err := p.metricsQueryAuthToken(cfg.MetricsQuery)
+		if err != nil {
+			return false, sourcePromQL, err
+		}
+		ok, err = evaluateMetricsQuery(ctx, p.httpClient, authToken, cfg.MetricsQuery)
+		return ok, sourcePromQL, err
+	case cfg.External != nil:
+		ok, err = p.probeKubeconfig(ctx, cfg.External)
+		return ok, sourceKubeconfig, err
+	default:
+		return true, "", nil
+	}
+}
+
+// probeKubeconfig checks connectivity to the cluster described by the
+// kubeconfig stored in details.KubeconfigSecretName by issuing a discovery call.
+func (p *prober) probeKubeconfig(ctx context.Context, details *probeDetails) (bool, error) {
+	secret, err := p.secretLister.Secrets(p.namespace).Get(details.KubeconfigSecretName)
+	p.metrics.GetTargetFromCacheTotal.With(prometheus.Labels{
+		labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelResource: resourceSecrets,
+	}).Inc()
+	if err != nil {
+		return false, fmt.Errorf("could not get kubeconfig secret %s/%s: %w", p.namespace, details.KubeconfigSecretName, err)
+	}
+	kubeconfig, ok := secret.Data["kubeconfig"]
+	if !ok {
+		return false, fmt.Errorf("secret %s/%s has no kubeconfig key", p.namespace, details.KubeconfigSecretName)
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return false, fmt.Errorf("could not parse kubeconfig from secret %s/%s: %w", p.namespace, details.KubeconfigSecretName, err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return false, err
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// metricsQueryAuthToken resolves cfg.AuthSecretRef, if set, to the bearer
+// token evaluateMetricsQuery authenticates cfg.Endpoint with. It returns ""
+// when cfg carries no AuthSecretRef, meaning Endpoint needs no auth.
+func (p *prober) metricsQueryAuthToken(cfg *metricsQueryProbeDetails) (string, error) {
+	if cfg.AuthSecretRef == "" {
+		return "", nil
+	}
+	secret, err := p.secretLister.Secrets(p.namespace).Get(cfg.AuthSecretRef)
+	p.metrics.GetTargetFromCacheTotal.With(prometheus.Labels{
+		labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelResource: resourceSecrets,
+	}).Inc()
+	if err != nil {
+		return "", fmt.Errorf("could not get metricsQuery auth secret %s/%s: %w", p.namespace, cfg.AuthSecretRef, err)
+	}
+	token, ok := secret.Data["token"]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no token key", p.namespace, cfg.AuthSecretRef)
+	}
+	return string(token), nil
+}
+
+// scaleDown pins every DependantScales entry to zero replicas and sets
+// dwd_dependant_scaled_down for this probe to 1.
+func (p *prober) scaleDown(ctx context.Context) {
+	if err := p.setScale(ctx, func(ds *dependantScaleDetails) int32 { return 0 }); err != nil {
+		klog.Errorf("could not scale down dependants for probe %s/%s: %v", p.namespace, p.dependants.Name, err)
+		return
+	}
+	p.scaledDown = true
+	p.metrics.DependantScaledDown.With(prometheus.Labels{labelNamespace: p.namespace, labelProbe: p.dependants.Name}).Set(1)
+}
+
+// restoreScale restores every DependantScales entry to its recorded Replicas
+// and sets dwd_dependant_scaled_down for this probe back to 0.
+func (p *prober) restoreScale(ctx context.Context) {
+	err := p.setScale(ctx, func(ds *dependantScaleDetails) int32 {
+		if ds.Replicas == nil {
+			return 0
+		}
+		return *ds.Replicas
+	})
+	if err != nil {
+		klog.Errorf("could not restore dependants for probe %s/%s: %v", p.namespace, p.dependants.Name, err)
+		return
+	}
+	p.scaledDown = false
+	p.metrics.DependantScaledDown.With(prometheus.Labels{labelNamespace: p.namespace, labelProbe: p.dependants.Name}).Set(0)
+}
+
+// setScale applies target(ds) to every DependantScales entry via p.scalesGetter,
+// resolving each entry's GroupResource through p.mapper.
+func (p *prober) setScale(ctx context.Context, target func(ds *dependantScaleDetails) int32) error {
+	for _, ds := range p.dependants.DependantScales {
+		gv, err := schema.ParseGroupVersion(ds.ScaleRef.APIVersion)
+		if err != nil {
+			return fmt.Errorf("invalid scaleRef apiVersion %q: %w", ds.ScaleRef.APIVersion, err)
+		}
+		mapping, err := p.mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ds.ScaleRef.Kind}, gv.Version)
+		if err != nil {
+			return fmt.Errorf("could not resolve scaleRef %+v: %w", ds.ScaleRef, err)
+		}
+		gr := mapping.Resource.GroupResource()
+
+		currentScale, err := p.scalesGetter.Scales(p.namespace).Get(ctx, gr, ds.ScaleRef.Name, metav1.GetOptions{})
+		p.metrics.ScaleRequestsTotal.With(prometheus.Labels{
+			labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelVerb: verbGet,
+		}).Inc()
+		if err != nil {
+			return fmt.Errorf("could not get scale for %+v: %w", ds.ScaleRef, err)
+		}
+
+		currentScale.Spec.Replicas = target(ds)
+		_, err = p.scalesGetter.Scales(p.namespace).Update(ctx, gr, currentScale, metav1.UpdateOptions{})
+		p.metrics.ScaleRequestsTotal.With(prometheus.Labels{
+			labelNamespace: p.namespace, labelProbe: p.dependants.Name, labelVerb: verbUpdate,
+		}).Inc()
+		if err != nil {
+			return fmt.Errorf("could not update scale for %+v: %w", ds.ScaleRef, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubernetesscheme "k8s.io/client-go/kubernetes/scheme"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	scalefake "k8s.io/client-go/scale/fake"
+	clientgotesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestProberScaleDownAndRestoreUpdatesGauge(t *testing.T) {
+	mapper := testrestmapper.TestOnlyStaticRESTMapper(kubernetesscheme.Scheme)
+
+	var currentReplicas int32 = 3
+	fakeScale := &scalefake.FakeScaleClient{}
+	fakeScale.AddReactor("get", "deployments", func(clientgotesting.Action) (bool, runtime.Object, error) {
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "shoot--foo--bar"},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: currentReplicas},
+		}, nil
+	})
+	fakeScale.AddReactor("update", "deployments", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		updated := action.(clientgotesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		currentReplicas = updated.Spec.Replicas
+		return true, updated, nil
+	})
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	replicas := int32(3)
+	dependants := probeDependants{
+		Name: "etcd",
+		DependantScales: []*dependantScaleDetails{{
+			ScaleRef: autoscalingv1.CrossVersionObjectReference{Kind: "Deployment", APIVersion: "apps/v1", Name: "etcd"},
+			Replicas: &replicas,
+		}},
+	}
+	p := newProber("shoot--foo--bar", dependants, mapper, nil, fakeScale, metrics, make(chan struct{}))
+	scaledDownGauge := metrics.DependantScaledDown.With(prometheus.Labels{labelNamespace: "shoot--foo--bar", labelProbe: "etcd"})
+
+	p.scaleDown(context.Background())
+	if currentReplicas != 0 {
+		t.Fatalf("expected scale-down to set replicas to 0, got %d", currentReplicas)
+	}
+	if got := testutil.ToFloat64(scaledDownGauge); got != 1 {
+		t.Fatalf("expected dwd_dependant_scaled_down to read 1 after scale-down, got %v", got)
+	}
+
+	p.restoreScale(context.Background())
+	if currentReplicas != 3 {
+		t.Fatalf("expected restore to set replicas back to 3, got %d", currentReplicas)
+	}
+	if got := testutil.ToFloat64(scaledDownGauge); got != 0 {
+		t.Fatalf("expected dwd_dependant_scaled_down to read 0 after restore, got %v", got)
+	}
+}
+
+func TestProberEvaluationDelayElapsed(t *testing.T) {
+	delay := int32(60)
+	p := &prober{dependants: probeDependants{EvaluationDelay: &delay}}
+
+	if !p.evaluationDelayElapsed(time.Now().Add(-90 * time.Second)) {
+		t.Fatal("expected the evaluation delay to have elapsed 90s into a 60s EvaluationDelay")
+	}
+	if p.evaluationDelayElapsed(time.Now().Add(-10 * time.Second)) {
+		t.Fatal("expected the evaluation delay not to have elapsed 10s into a 60s EvaluationDelay")
+	}
+
+	noDelay := &prober{}
+	if !noDelay.evaluationDelayElapsed(time.Now()) {
+		t.Fatal("expected a nil EvaluationDelay to never gate a scale change")
+	}
+}
+
+func TestProberMetricsQueryAuthToken(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "promql-auth", Namespace: "shoot--foo--bar"},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	if err := indexer.Add(secret); err != nil {
+		t.Fatalf("could not seed secret indexer: %v", err)
+	}
+	p := &prober{
+		namespace:    "shoot--foo--bar",
+		dependants:   probeDependants{Name: "etcd"},
+		secretLister: corelisters.NewSecretLister(indexer),
+		metrics:      NewMetrics(prometheus.NewRegistry()),
+	}
+
+	token, err := p.metricsQueryAuthToken(&metricsQueryProbeDetails{AuthSecretRef: "promql-auth"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token != "s3cr3t" {
+		t.Fatalf("expected resolved token %q, got %q", "s3cr3t", token)
+	}
+
+	if token, err := p.metricsQueryAuthToken(&metricsQueryProbeDetails{}); err != nil || token != "" {
+		t.Fatalf("expected no AuthSecretRef to resolve to an empty token, got %q, err %v", token, err)
+	}
+
+	if _, err := p.metricsQueryAuthToken(&metricsQueryProbeDetails{AuthSecretRef: "missing"}); err == nil {
+		t.Fatal("expected an error when the auth secret does not exist")
+	}
+}
@@ -15,12 +15,13 @@
 package scaler
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/gardener/dependency-watchdog/pkg/multicontext"
-	"github.com/prometheus/client_golang/prometheus"
 	autoscaling "k8s.io/api/autoscaling/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listerappsv1 "k8s.io/client-go/listers/apps/v1"
@@ -35,6 +36,7 @@ import (
 type Controller struct {
 	client               kubernetes.Interface
 	mapper               apimeta.RESTMapper
+	dynamicClient        dynamic.Interface
 	scalesGetter         scale.ScalesGetter
 	informerFactory      informers.SharedInformerFactory
 	secretsInformer      cache.SharedIndexInformer
@@ -46,7 +48,9 @@ type Controller struct {
 	hasDeploymentsSynced cache.InformerSynced
 	stopCh               <-chan struct{}
 	probeDependantsList  *ProbeDependantsList
-	probers              map[string]*prober // the key is <namespace>/<probeDependents.Name>
+	probers              map[string]*prober       // the key is <namespace>/<probeDependents.Name>
+	proberStopChs        map[string]chan struct{} // per-prober stop signal, closed when its entry is removed
+	metrics              *Metrics
 	mux                  sync.Mutex
 	*multicontext.Multicontext
 	// LeaderElection defines the configuration of leader election client.
@@ -60,28 +64,98 @@ type Controller struct {
 type ProbeDependantsList struct {
 	Probes    []probeDependants `json:"probes"`
 	Namespace string            `json:"namespace"`
+	// Monitoring configures the ServiceMonitor(s) pkg/scaler reconciles for the
+	// dependant Deployments' Services, so that a Prometheus Operator-managed
+	// Prometheus automatically scrapes the workloads the watchdog is scaling.
+	// Left nil, no ServiceMonitor is reconciled.
+	Monitoring *monitoringConfig `json:"monitoring,omitempty"`
+}
+
+// monitoringConfig holds the scrape configuration applied to the ServiceMonitor(s)
+// the scaler reconciles for every probeDependants entry's dependant Deployments.
+type monitoringConfig struct {
+	// Port is the name of the Service port to scrape.
+	Port string `json:"port"`
+	// Path is the HTTP path to scrape metrics from. Defaults to "/metrics".
+	Path string `json:"path,omitempty"`
+	// Interval is the scrape interval, e.g. "30s". Defaults to the Prometheus Operator default.
+	Interval string `json:"interval,omitempty"`
+	// ScrapeTimeout is the per-scrape timeout, e.g. "10s".
+	ScrapeTimeout string `json:"scrapeTimeout,omitempty"`
+	// TLSConfig is passed through verbatim as the ServiceMonitor endpoint's tlsConfig.
+	TLSConfig map[string]interface{} `json:"tlsConfig,omitempty"`
+	// Relabelings is passed through verbatim as the ServiceMonitor endpoint's relabelings.
+	Relabelings []map[string]interface{} `json:"relabelings,omitempty"`
 }
 
 type probeDependants struct {
 	Name            string                   `json:"name"`
 	Probe           *probeConfig             `json:"probe"`
 	DependantScales []*dependantScaleDetails `json:"dependantScales"`
+	// EvaluationDelay is the duration, in seconds, that a probe's result must be
+	// sustained for before it is allowed to flip the scale of DependantScales.
+	// It smooths over flaky MetricsQuery probes so a single bad sample cannot
+	// trigger a scale change.
+	EvaluationDelay *int32 `json:"evaluationDelay,omitempty"`
 }
 
 type probeConfig struct {
-	External            *probeDetails `json:"external,omitempty"`
-	Internal            *probeDetails `json:"internal,omitempty"`
-	InitialDelaySeconds *int32        `json:"initialDelaySeconds,omitempty"`
-	TimeoutSeconds      *int32        `json:"timeoutSeconds,omitempty"`
-	PeriodSeconds       *int32        `json:"periodSeconds,omitempty"`
-	SuccessThreshold    *int32        `json:"successThreshold,omitempty"`
-	FailureThreshold    *int32        `json:"failureThreshold,omitempty"`
+	External            *probeDetails             `json:"external,omitempty"`
+	Internal            *probeDetails             `json:"internal,omitempty"`
+	MetricsQuery        *metricsQueryProbeDetails `json:"metricsQuery,omitempty"`
+	InitialDelaySeconds *int32                    `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      *int32                    `json:"timeoutSeconds,omitempty"`
+	PeriodSeconds       *int32                    `json:"periodSeconds,omitempty"`
+	SuccessThreshold    *int32                    `json:"successThreshold,omitempty"`
+	FailureThreshold    *int32                    `json:"failureThreshold,omitempty"`
 }
 
 type probeDetails struct {
 	KubeconfigSecretName string `json:"kubeconfigSecretName"`
 }
 
+// Validate checks that every probeConfig in l is well-formed, in particular that
+// a configured MetricsQuery probe carries a Threshold. NewController calls
+// Validate, so a missing threshold is rejected when the Controller is
+// constructed instead of making every evaluation of the probe return an error.
+func (l *ProbeDependantsList) Validate() error {
+	for _, d := range l.Probes {
+		if d.Probe == nil || d.Probe.MetricsQuery == nil {
+			continue
+		}
+		if d.Probe.MetricsQuery.Threshold == nil {
+			return fmt.Errorf("probe %q: metricsQuery requires a threshold", d.Name)
+		}
+	}
+	return nil
+}
+
+// metricsQueryProbeDetails holds the configuration for a probe that evaluates
+// a PromQL expression against a Prometheus-compatible HTTP endpoint instead of
+// checking kubeconfig connectivity. It is an alternative to probeDetails for
+// driving the external half of a probeConfig.
+type metricsQueryProbeDetails struct {
+	// Endpoint is the base URL (or in-cluster service reference) of the
+	// Prometheus-compatible HTTP API that Query is evaluated against.
+	Endpoint string `json:"endpoint"`
+	// Query is the PromQL expression issued as an instant query on every probe tick.
+	Query string `json:"query"`
+	// AuthSecretRef names a Secret, in ProbeDependantsList.Namespace, whose contents
+	// are used to authenticate against Endpoint. Optional if Endpoint needs no auth.
+	AuthSecretRef string `json:"authSecretRef,omitempty"`
+	// Threshold is the predicate applied to the query result to decide whether
+	// the probe succeeded.
+	Threshold *thresholdPredicate `json:"threshold"`
+}
+
+// thresholdPredicate compares a MetricsQuery probe's result against Value using Operator.
+type thresholdPredicate struct {
+	// Operator is one of "==", "!=", "<", "<=", ">", ">=".
+	Operator string `json:"operator"`
+	// Value is the right-hand-side operand compared against the query result.
+	Value float64 `json:"value"`
+}
+
 type dependantScaleDetails struct {
 	ScaleRef autoscaling.CrossVersionObjectReference `json:"scaleRef"`
 	Replicas *int32                                  `json:"replicas"`
@@ -100,88 +174,12 @@ const (
 	verbDiscovery       = "discovery"
 	verbGet             = "GET"
 	verbUpdate          = "UPDATE"
+	labelSource         = "source"
+	sourceKubeconfig    = "kubeconfig"
+	sourcePromQL        = "promql"
+	labelProbe          = "probe"
+	labelNamespace      = "namespace"
 )
 
-var (
-	dwdProbersTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "probers_total",
-			Help:      "The accumulated total number of probers started by the dependency-watchdog.",
-		},
-		nil,
-	)
-
-	dwdGetTargetFromCacheTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "get_from_cache_total",
-			Help:      "The accumulated total number get calls done by the dependency-watchdog on the local cache.",
-		},
-		[]string{labelResource},
-	)
-
-	dwdInternalProbesTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "internal_probes_total",
-			Help:      "The accumulated total number of internal probes done by the dependency-watchdog.",
-		},
-		[]string{labelResult},
-	)
-
-	dwdExternalProbesTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "external_probes_total",
-			Help:      "The accumulated total number of external probes done by the dependency-watchdog.",
-		},
-		[]string{labelResult},
-	)
-
-	dwdScaleRequestsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "scale_requests_total",
-			Help:      "The accumulated total number of scale client requests made by the dependency-watchdog.",
-		},
-		[]string{labelVerb},
-	)
-
-	dwdThrottledScaleRequestsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: dwdNamespace,
-			Subsystem: subsystemAggregate,
-			Name:      "throttled_scale_requests_total",
-			Help:      "The accumulated total number of throttled scale client requests made by the dependency-watchdog.",
-		},
-		[]string{labelVerb},
-	)
-)
-
-func init() {
-	// Initialize labelled metrics
-	for _, lr := range []string{resultSuccess, resultFailure} {
-		dwdInternalProbesTotal.With(prometheus.Labels{labelResult: lr}).Add(0)
-		dwdExternalProbesTotal.With(prometheus.Labels{labelResult: lr}).Add(0)
-	}
-	for _, lr := range []string{resourceSecrets, resourceDeployments} {
-		dwdGetTargetFromCacheTotal.With(prometheus.Labels{labelResource: lr}).Add(0)
-	}
-	for _, lv := range []string{verbDiscovery, verbGet, verbUpdate} {
-		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: lv}).Add(0)
-		dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: lv}).Add(0)
-	}
-
-	prometheus.MustRegister(dwdProbersTotal)
-	prometheus.MustRegister(dwdGetTargetFromCacheTotal)
-	prometheus.MustRegister(dwdInternalProbesTotal)
-	prometheus.MustRegister(dwdExternalProbesTotal)
-	prometheus.MustRegister(dwdScaleRequestsTotal)
-	prometheus.MustRegister(dwdThrottledScaleRequestsTotal)
-}
+// See metrics.go for the dwd* collectors themselves, which now live on a per-Controller
+// Metrics struct instead of being registered against prometheus.DefaultRegisterer here.